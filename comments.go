@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// Field numbers of FileDescriptorProto/DescriptorProto/ServiceDescriptorProto
+// used to build SourceCodeInfo paths. These mirror
+// the wire field numbers in descriptor.proto, the same convention
+// protoc-gen-go's generator relies on to locate comments.
+var (
+	fileMessagePath = []int32{4} // FileDescriptorProto.message_type
+	fileEnumPath    = []int32{5} // FileDescriptorProto.enum_type
+	fileServicePath = []int32{6} // FileDescriptorProto.service
+
+	messageFieldPath      = int32(2) // DescriptorProto.field
+	messageNestedTypePath = int32(3) // DescriptorProto.nested_type
+	messageEnumTypePath   = int32(4) // DescriptorProto.enum_type
+
+	serviceMethodPath = int32(2) // ServiceDescriptorProto.method
+)
+
+// commentMap indexes a FileDescriptorProto's SourceCodeInfo locations by
+// their dotted field-number path (e.g. "4,0,2,1" for the second field of
+// the first top-level message), the same path encoding protoc-gen-go uses
+// to thread comments through its own generator.
+type commentMap map[string]string
+
+// buildComments walks file.GetSourceCodeInfo().GetLocation() and returns a
+// map from descriptor path to its formatted leading (or, failing that,
+// trailing) comment, formatted by formatComment (leading "*" block-comment
+// markers and the space protoc leaves before each line stripped).
+func buildComments(file *descriptor.FileDescriptorProto) commentMap {
+	comments := commentMap{}
+
+	for _, loc := range file.GetSourceCodeInfo().GetLocation() {
+		comment := loc.GetLeadingComments()
+		if comment == "" {
+			comment = loc.GetTrailingComments()
+		}
+		if comment == "" {
+			continue
+		}
+
+		comments[pathKey(loc.GetPath())] = formatComment(comment)
+	}
+
+	return comments
+}
+
+func (c commentMap) get(path []int32) string {
+	return c[pathKey(path)]
+}
+
+// appendPath returns a copy of path with more appended, so callers can
+// safely branch a path for sibling descriptors without aliasing.
+func appendPath(path []int32, more ...int32) []int32 {
+	p := make([]int32, len(path), len(path)+len(more))
+	copy(p, path)
+	return append(p, more...)
+}
+
+func pathKey(path []int32) string {
+	parts := make([]string, len(path))
+	for i, v := range path {
+		parts[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(parts, ",")
+}
+
+// formatComment turns a raw SourceCodeInfo comment (which retains a
+// leading space and, for "/** ... */" blocks, a leading "*" per line)
+// into plain text ready to render inside a "/** ... */" TSDoc block.
+func formatComment(raw string) string {
+	lines := strings.Split(strings.TrimRight(raw, "\n"), "\n")
+
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		line = strings.TrimPrefix(line, "*")
+		line = strings.TrimPrefix(line, " ")
+		out = append(out, line)
+	}
+
+	for len(out) > 0 && out[0] == "" {
+		out = out[1:]
+	}
+	for len(out) > 0 && out[len(out)-1] == "" {
+		out = out[:len(out)-1]
+	}
+
+	return strings.Join(out, "\n")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
@@ -25,6 +26,11 @@ func fullTypeName(fd *descriptor.FileDescriptorProto, typeName string) string {
 }
 
 func generate(req *plugin.CodeGeneratorRequest) (*plugin.CodeGeneratorResponse, error) {
+	p, err := parseParameter(req.GetParameter())
+	if err != nil {
+		return nil, err
+	}
+
 	resolver := dependencyResolver{}
 
 	res := &plugin.CodeGeneratorResponse{
@@ -36,24 +42,37 @@ func generate(req *plugin.CodeGeneratorRequest) (*plugin.CodeGeneratorResponse,
 		},
 	}
 
+	if p.Server {
+		res.File = append(res.File, &plugin.CodeGeneratorResponse_File{
+			Name:    &twirpServerFileName,
+			Content: &twirpServerSource,
+		})
+	}
+
 	protoFiles := req.GetProtoFile()
 	for i := range protoFiles {
 		file := protoFiles[i]
+		comments := buildComments(file)
 
 		pfile := &protoFile{
-			Imports:  map[string]*importValues{},
-			Messages: []*messageValues{},
-			Services: []*serviceValues{},
-			Enums:    []*enumValues{},
+			Imports:      map[string]*importValues{},
+			Messages:     []*messageValues{},
+			Services:     []*serviceValues{},
+			Enums:        []*enumValues{},
+			ImportStyle:  p.ImportStyle,
+			Runtime:      p.Runtime,
+			EmitDefaults: p.EmitDefaults,
+			Server:       p.Server,
 		}
 
 		// Add enum
-		for _, enum := range file.GetEnumType() {
+		for enumIdx, enum := range file.GetEnumType() {
 			resolver.Set(file, enum.GetName())
 
 			v := &enumValues{
-				Name:   enum.GetName(),
-				Values: []*enumKeyVal{},
+				Name:    enum.GetName(),
+				Comment: comments.get(appendPath(fileEnumPath, int32(enumIdx))),
+				Values:  []*enumKeyVal{},
 			}
 
 			for _, value := range enum.GetValue() {
@@ -67,91 +86,33 @@ func generate(req *plugin.CodeGeneratorRequest) (*plugin.CodeGeneratorResponse,
 		}
 
 		// Add messages
-		for _, message := range file.GetMessageType() {
-			name := message.GetName()
-			tsInterface := typeToInterface(name)
-			jsonInterface := typeToJSONInterface(name)
-
-			resolver.Set(file, name)
-			resolver.Set(file, tsInterface)
-			resolver.Set(file, jsonInterface)
-
-			v := &messageValues{
-				Name:          name,
-				Interface:     tsInterface,
-				JSONInterface: jsonInterface,
-
-				Fields:      []*fieldValues{},
-				NestedTypes: []*messageValues{},
-				NestedEnums: []*enumValues{},
-			}
-
-			for _, m := message.GetMessageType() {
-				// TODO: add support for nested messages
-				// https://developers.google.com/protocol-buffers/docs/proto#nested
-				log.Fatal("nested messages are not supported yet")
-			}
-
-			// Add nested enums
-			for _, enum := range message.GetEnumType() {
-				e := &enumValues{
-					Name:   fmt.Sprintf("%s_%s", message.GetName(), enum.GetName()),
-					Values: []*enumKeyVal{},
-				}
-
-				for _, value := range enum.GetValue() {
-					e.Values = append(e.Values, &enumKeyVal{
-						Name:  value.GetName(),
-						Value: value.GetNumber(),
-					})
-				}
-
-				v.NestedEnums = append(v.NestedEnums, e)
-			}
-
-			// Add message fields
-			for _, field := range message.GetField() {
-				fp, err := resolver.Resolve(field.GetTypeName())
-				if err == nil {
-					if !samePackage(fp, file) {
-						pfile.Imports[fp.GetName()] = &importValues{
-							Name: importName(fp),
-							Path: importPath(file, fp.GetName()),
-						}
-					}
-				}
-
-				v.Fields = append(v.Fields, &fieldValues{
-					Name:  field.GetName(),
-					Field: camelCase(field.GetName()),
-
-					Type:       resolver.TypeName(file, singularFieldType(field)),
-					IsRepeated: isRepeated(field),
-				})
-			}
-
+		for msgIdx, message := range file.GetMessageType() {
+			path := appendPath(fileMessagePath, int32(msgIdx))
+			v := buildMessage(file, message, &resolver, pfile, p, comments, path, "")
 			pfile.Messages = append(pfile.Messages, v)
 		}
 
 		// Add services
-		for _, service := range file.GetService() {
+		for svcIdx, service := range file.GetService() {
 			resolver.Set(file, service.GetName())
+			svcPath := appendPath(fileServicePath, int32(svcIdx))
 
 			v := &serviceValues{
 				Package:   file.GetPackage(),
 				Name:      service.GetName(),
+				Comment:   comments.get(svcPath),
 				Interface: typeToInterface(service.GetName()),
 				Methods:   []*serviceMethodValues{},
 			}
 
-			for _, method := range service.GetMethod() {
+			for methodIdx, method := range service.GetMethod() {
 				{
 					fp, err := resolver.Resolve(method.GetInputType())
 					if err == nil {
 						if !samePackage(fp, file) {
 							pfile.Imports[fp.GetName()] = &importValues{
 								Name: importName(fp),
-								Path: importPath(file, fp.GetName()),
+								Path: importPath(file, fp.GetName(), p),
 							}
 						}
 					}
@@ -163,7 +124,7 @@ func generate(req *plugin.CodeGeneratorRequest) (*plugin.CodeGeneratorResponse,
 						if !samePackage(fp, file) {
 							pfile.Imports[fp.GetName()] = &importValues{
 								Name: importName(fp),
-								Path: importPath(file, fp.GetName()),
+								Path: importPath(file, fp.GetName(), p),
 							}
 						}
 					}
@@ -171,8 +132,10 @@ func generate(req *plugin.CodeGeneratorRequest) (*plugin.CodeGeneratorResponse,
 
 				v.Methods = append(v.Methods, &serviceMethodValues{
 					Name:       method.GetName(),
+					Comment:    comments.get(appendPath(svcPath, serviceMethodPath, int32(methodIdx))),
 					InputType:  resolver.TypeName(file, removePkg(method.GetInputType())),
 					OutputType: resolver.TypeName(file, removePkg(method.GetOutputType())),
+					Path:       twirpRoutePath(file.GetPackage(), service.GetName(), method.GetName()),
 				})
 			}
 
@@ -185,6 +148,19 @@ func generate(req *plugin.CodeGeneratorRequest) (*plugin.CodeGeneratorResponse,
 			log.Fatal("could not compile template: ", err)
 		}
 
+		if p.Server && len(pfile.Services) > 0 {
+			var b strings.Builder
+			fmt.Fprintf(&b, "\nimport { createTwirpHandler, TwirpServerRequest, TwirpServerResponse, TwirpServiceDefinition, TwirpServiceImpl } from %q;\n", importPath(file, twirpServerFileName, p))
+			for _, svc := range pfile.Services {
+				b.WriteString(renderServiceServer(svc))
+			}
+			s += b.String()
+		}
+
+		if p.TSNoCheck {
+			s = "// @ts-nocheck\n" + s
+		}
+
 		fileName := tsFileName(file.GetName())
 		log.Printf("wrote: %v", fileName)
 
@@ -197,6 +173,241 @@ func generate(req *plugin.CodeGeneratorRequest) (*plugin.CodeGeneratorResponse,
 	return res, nil
 }
 
+// buildMessage walks a DescriptorProto and its nested_type/enum_type
+// children recursively, registering each nested message with the resolver
+// under its fully-qualified name (e.g. "Outer.Inner") so that sibling and
+// descendant messages can reference it by that path, the same way
+// protoc-gen-go resolves nested_type descriptors.
+//
+// qualifiedPrefix is the dotted path of the enclosing message, or "" for
+// a top-level message.
+func buildMessage(file *descriptor.FileDescriptorProto, message *descriptor.DescriptorProto, resolver *dependencyResolver, pfile *protoFile, p *params, comments commentMap, path []int32, qualifiedPrefix string) *messageValues {
+	name := message.GetName()
+	qualifiedName := name
+	if qualifiedPrefix != "" {
+		qualifiedName = qualifiedPrefix + "." + name
+	}
+
+	tsInterface := typeToInterface(qualifiedName)
+	jsonInterface := typeToJSONInterface(qualifiedName)
+
+	resolver.Set(file, qualifiedName)
+	resolver.Set(file, tsInterface)
+	resolver.Set(file, jsonInterface)
+
+	v := &messageValues{
+		Name:          name,
+		QualifiedName: qualifiedName,
+		Comment:       comments.get(path),
+		Interface:     tsInterface,
+		JSONInterface: jsonInterface,
+
+		Fields:      []*fieldValues{},
+		NestedTypes: []*messageValues{},
+		NestedEnums: []*enumValues{},
+		Oneofs:      []*oneofValues{},
+	}
+
+	// Add nested messages, skipping the synthetic "FooEntry" messages
+	// protoc generates for map<K, V> fields: those are rendered inline as
+	// an index signature on the field itself (see mapEntryType below),
+	// not as a nested type.
+	for nestedIdx, nested := range message.GetNestedType() {
+		if nested.GetOptions().GetMapEntry() {
+			continue
+		}
+
+		nestedPath := appendPath(path, messageNestedTypePath, int32(nestedIdx))
+		v.NestedTypes = append(v.NestedTypes, buildMessage(file, nested, resolver, pfile, p, comments, nestedPath, qualifiedName))
+	}
+
+	// Add nested enums. Register each one with the resolver under its
+	// dotted qualified name (e.g. "Outer.Middle.Status"), the same
+	// convention nested messages use above, since that's the form
+	// resolver.Resolve is called with (the proto type system has no
+	// notion of the flattened identifier below). The rendered Name is
+	// flattened to a top-level identifier built from the full qualified
+	// path of the enclosing message (e.g. "Outer_Middle_Status"), not
+	// just its immediate parent, so that two differently-nested messages
+	// sharing a local enum name don't collide; singularFieldType's
+	// TYPE_ENUM case flattens the resolved qualified name the same way to
+	// produce a matching reference.
+	for enumIdx, enum := range message.GetEnumType() {
+		enumQualifiedName := qualifiedName + "." + enum.GetName()
+		resolver.Set(file, enumQualifiedName)
+
+		e := &enumValues{
+			Name:    flattenQualifiedName(enumQualifiedName),
+			Comment: comments.get(appendPath(path, messageEnumTypePath, int32(enumIdx))),
+			Values:  []*enumKeyVal{},
+		}
+
+		for _, value := range enum.GetValue() {
+			e.Values = append(e.Values, &enumKeyVal{
+				Name:  value.GetName(),
+				Value: value.GetNumber(),
+			})
+		}
+
+		v.NestedEnums = append(v.NestedEnums, e)
+	}
+
+	// Add message fields
+	oneofFields := map[int32][]*fieldValues{}
+	for fieldIdx, field := range message.GetField() {
+		// Well-known types are built into the generated runtime rather
+		// than imported, so don't resolve or register them as imports.
+		var fp *descriptor.FileDescriptorProto
+		if _, ok := wellKnownType(field.GetTypeName()); !ok {
+			var err error
+			fp, err = resolver.Resolve(field.GetTypeName())
+			if err == nil {
+				if !samePackage(fp, file) {
+					pfile.Imports[fp.GetName()] = &importValues{
+						Name: importName(fp),
+						Path: importPath(file, fp.GetName(), p),
+					}
+				}
+			}
+		}
+
+		// proto3 map<K, V> fields are represented on the wire as a repeated
+		// message field pointing at a synthetic nested "FooEntry" message
+		// with MessageOptions.map_entry set. Render them as an index
+		// signature instead of an array of entry messages.
+		if entry, ok := mapEntryType(message, field); ok {
+			keyField := entry.GetField()[0]
+			valField := entry.GetField()[1]
+
+			var valFP *descriptor.FileDescriptorProto
+			if _, ok := wellKnownType(valField.GetTypeName()); !ok {
+				var err error
+				valFP, err = resolver.Resolve(valField.GetTypeName())
+				if err == nil {
+					if !samePackage(valFP, file) {
+						pfile.Imports[valFP.GetName()] = &importValues{
+							Name: importName(valFP),
+							Path: importPath(file, valFP.GetName(), p),
+						}
+					}
+				}
+			}
+
+			fv := &fieldValues{
+				Name:    field.GetName(),
+				Field:   camelCase(field.GetName()),
+				Comment: comments.get(appendPath(path, messageFieldPath, int32(fieldIdx))),
+
+				Type:       fmt.Sprintf("{ [key: %s]: %s }", singularFieldType(keyField, nil), resolver.TypeName(file, singularFieldType(valField, valFP))),
+				JSONType:   fmt.Sprintf("{ [key: %s]: %s }", singularFieldType(keyField, nil), resolver.TypeName(file, singularFieldJSONType(valField, valFP))),
+				IsRepeated: false,
+			}
+
+			v.Fields = append(v.Fields, fv)
+			continue
+		}
+
+		fv := &fieldValues{
+			Name:    field.GetName(),
+			Field:   camelCase(field.GetName()),
+			Comment: comments.get(appendPath(path, messageFieldPath, int32(fieldIdx))),
+
+			Type:       resolver.TypeName(file, singularFieldType(field, fp)),
+			JSONType:   resolver.TypeName(file, singularFieldJSONType(field, fp)),
+			IsRepeated: isRepeated(field),
+		}
+
+		v.Fields = append(v.Fields, fv)
+
+		// A proto3 `optional` scalar field desugars to its own
+		// single-member synthetic oneof (GetProto3Optional() true) purely
+		// so field-presence can be tracked; it's still just a normal
+		// field, not a real discriminated union, so exclude it here.
+		if field.OneofIndex != nil && !field.GetProto3Optional() {
+			idx := field.GetOneofIndex()
+			oneofFields[idx] = append(oneofFields[idx], fv)
+		}
+	}
+
+	// Group fields that share a oneof_index into a discriminated union
+	// per oneof declaration. Synthetic proto3-optional oneofs never
+	// accumulate members above, so they're naturally skipped here too.
+	for idx, decl := range message.GetOneofDecl() {
+		fields, ok := oneofFields[int32(idx)]
+		if !ok {
+			continue
+		}
+
+		v.Oneofs = append(v.Oneofs, &oneofValues{
+			Name:   decl.GetName(),
+			Field:  camelCase(decl.GetName()),
+			Fields: fields,
+		})
+	}
+
+	return v
+}
+
+// mapEntryType reports whether field is a proto3 map field by looking for
+// a nested message on its declaring message that matches the field's
+// type name and has MessageOptions.map_entry set, per the map_entry
+// convention used by protoc to desugar "map<K, V>" into a synthetic
+// repeated message field.
+func mapEntryType(message *descriptor.DescriptorProto, field *descriptor.FieldDescriptorProto) (*descriptor.DescriptorProto, bool) {
+	if field.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+		return nil, false
+	}
+
+	entryName := removePkg(field.GetTypeName())
+	for _, nested := range message.GetNestedType() {
+		if nested.GetName() == entryName && nested.GetOptions().GetMapEntry() {
+			return nested, true
+		}
+	}
+
+	return nil, false
+}
+
+// twirpRoutePath builds the HTTP path Twirp routes a method under:
+// POST /twirp/<pkg>.<Service>/<Method>
+func twirpRoutePath(pkg, service, method string) string {
+	fqs := service
+	if pkg != "" {
+		fqs = pkg + "." + service
+	}
+	return fmt.Sprintf("/twirp/%s/%s", fqs, method)
+}
+
+// renderServiceServer emits the server=true counterpart to a service's
+// client interface: a TwirpServiceDefinition describing each method's
+// route (keyed by method.Path, computed via twirpRoutePath) and a
+// createXxxServer(impl) wrapper that hands that definition to
+// createTwirpHandler from the generated twirp_server.ts runtime, so
+// consumers wire a service up with a single call rather than hand-rolling
+// a TwirpServiceDefinition themselves.
+func renderServiceServer(v *serviceValues) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "\nexport const %sDefinition: TwirpServiceDefinition = {\n", v.Name)
+	fmt.Fprintf(&b, "  name: %q,\n", v.Name)
+	b.WriteString("  methods: [\n")
+	for _, m := range v.Methods {
+		name := camelCase(m.Name)
+		if name != "" {
+			name = strings.ToLower(name[0:1]) + name[1:]
+		}
+		fmt.Fprintf(&b, "    { name: %q, path: %q },\n", name, m.Path)
+	}
+	b.WriteString("  ],\n")
+	b.WriteString("};\n")
+
+	fmt.Fprintf(&b, "\nexport function create%sServer(impl: TwirpServiceImpl): (req: TwirpServerRequest, res: TwirpServerResponse) => Promise<void> {\n", v.Name)
+	fmt.Fprintf(&b, "  return createTwirpHandler(%sDefinition, impl);\n", v.Name)
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
 func isRepeated(field *descriptor.FieldDescriptorProto) bool {
 	return field.Label != nil && *field.Label == descriptor.FieldDescriptorProto_LABEL_REPEATED
 }
@@ -239,37 +450,83 @@ func tsImportPath(name string) string {
 	return name
 }
 
-func importPath(fd *descriptor.FileDescriptorProto, name string) string {
-	// TODO: how to resolve relative paths?
-	return tsImportPath(name)
+func importPath(fd *descriptor.FileDescriptorProto, name string, p *params) string {
+	if p != nil && p.Paths == PathsSourceRelative {
+		return tsImportPath(name)
+	}
+
+	// paths=import (the default): resolve name relative to the directory
+	// of the importing file, so the emitted import specifier works
+	// regardless of where OUTDIR places either file.
+	rel, err := filepath.Rel(path.Dir(fd.GetName()), tsImportPath(name))
+	if err != nil {
+		return tsImportPath(name)
+	}
+
+	// filepath.Rel uses the OS path separator, but the emitted string is
+	// an ES module/CommonJS import specifier, which is always
+	// forward-slash regardless of the host OS.
+	rel = filepath.ToSlash(rel)
+
+	if !strings.HasPrefix(rel, ".") {
+		rel = "./" + rel
+	}
+
+	return rel
 }
 
 func tsFileName(name string) string {
 	return tsImportPath(name) + ".ts"
 }
 
-func singularFieldType(f *descriptor.FieldDescriptorProto) string {
+func singularFieldType(f *descriptor.FieldDescriptorProto, fp *descriptor.FileDescriptorProto) string {
 	switch f.GetType() {
 	case descriptor.FieldDescriptorProto_TYPE_DOUBLE,
+		descriptor.FieldDescriptorProto_TYPE_FLOAT,
 		descriptor.FieldDescriptorProto_TYPE_FIXED32,
-		descriptor.FieldDescriptorProto_TYPE_FIXED64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32,
 		descriptor.FieldDescriptorProto_TYPE_INT32,
-		descriptor.FieldDescriptorProto_TYPE_INT64:
+		descriptor.FieldDescriptorProto_TYPE_UINT32,
+		descriptor.FieldDescriptorProto_TYPE_SINT32:
 		return "number"
+	case descriptor.FieldDescriptorProto_TYPE_INT64,
+		descriptor.FieldDescriptorProto_TYPE_UINT64,
+		descriptor.FieldDescriptorProto_TYPE_SINT64,
+		descriptor.FieldDescriptorProto_TYPE_FIXED64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		// 64-bit integers don't fit in a JS number without loss of
+		// precision, so jsonpb encodes them as strings. Accept either
+		// representation on the TypeScript side.
+		return "string | number"
 	case descriptor.FieldDescriptorProto_TYPE_STRING:
 		return "string"
 	case descriptor.FieldDescriptorProto_TYPE_BOOL:
 		return "boolean"
-	case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		return "Uint8Array"
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		name := f.GetTypeName()
+		if fp != nil {
+			// Nested enums are flattened to "Outer_Middle_Status" (see
+			// buildMessage), so flatten the resolved qualified name the
+			// same way to get a matching reference.
+			return flattenQualifiedName(qualifiedMessageName(fp, name))
+		}
+
+		return removePkg(name)
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_GROUP:
 		name := f.GetTypeName()
 
-		// Google WKT Timestamp is a special case here:
-		//
-		// Currently the value will just be left as jsonpb RFC 3339 string.
-		// JSON.stringify already handles serializing Date to its RFC 3339 format.
-		//
-		if name == ".google.protobuf.Timestamp" {
-			return "Date"
+		if wkt, ok := wellKnownType(name); ok {
+			return wkt.tsType()
+		}
+
+		// When the referenced message's file could be resolved, keep its
+		// full path relative to the package (e.g. "Outer.Inner") so that
+		// references to nested types stay qualified. Otherwise fall back
+		// to the bare type name.
+		if fp != nil {
+			return qualifiedMessageName(fp, name)
 		}
 
 		return removePkg(name)
@@ -278,12 +535,61 @@ func singularFieldType(f *descriptor.FieldDescriptorProto) string {
 	return "string"
 }
 
-func fieldType(f *fieldValues) string {
-	t := f.Type
-	if t == "Date" {
-		t = "string"
+// singularFieldJSONType mirrors singularFieldType but returns the type as
+// it appears in the generated JSON interface (the jsonpb wire
+// representation), which for well-known types and 64-bit integers differs
+// from the plain TypeScript interface.
+func singularFieldJSONType(f *descriptor.FieldDescriptorProto, fp *descriptor.FileDescriptorProto) string {
+	switch f.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_INT64,
+		descriptor.FieldDescriptorProto_TYPE_UINT64,
+		descriptor.FieldDescriptorProto_TYPE_SINT64,
+		descriptor.FieldDescriptorProto_TYPE_FIXED64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		// jsonpb always encodes 64-bit integers as a string.
+		return "string"
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		// jsonpb encodes bytes as a base64 string.
+		return "string"
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_GROUP:
+		name := f.GetTypeName()
+		if wkt, ok := wellKnownType(name); ok {
+			return wkt.jsonType()
+		}
 	}
+
+	return singularFieldType(f, fp)
+}
+
+// qualifiedMessageName strips the leading ".<package>." (or just ".") from a
+// fully-qualified proto type name, leaving the dotted path of the type
+// relative to its file's package, e.g. ".pkg.Outer.Inner" -> "Outer.Inner".
+func qualifiedMessageName(fp *descriptor.FileDescriptorProto, typeName string) string {
+	prefix := "."
+	if pkg := fp.GetPackage(); pkg != "" {
+		prefix = "." + pkg + "."
+	}
+	return strings.TrimPrefix(typeName, prefix)
+}
+
+// flattenQualifiedName turns a dotted qualified path (e.g. "Outer.Inner")
+// into a flat identifier-safe name (e.g. "Outer_Inner"), used for nested
+// enums, which are emitted as flat top-level declarations rather than
+// namespaced like nested messages.
+func flattenQualifiedName(s string) string {
+	return strings.ReplaceAll(s, ".", "_")
+}
+
+// fieldType returns the type to render in the generated JSON interface,
+// which is f.JSONType (the jsonpb wire representation computed alongside
+// f.Type by singularFieldJSONType) with repeated fields wrapped as an
+// array.
+func fieldType(f *fieldValues) string {
+	t := f.JSONType
 	if f.IsRepeated {
+		if strings.Contains(t, " | ") {
+			return "(" + t + ")[]"
+		}
 		return t + "[]"
 	}
 	return t
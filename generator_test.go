@@ -0,0 +1,365 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+func strPtr(s string) *string { return &s }
+func int32Ptr(i int32) *int32 { return &i }
+func boolPtr(b bool) *bool    { return &b }
+
+func fieldTypePtr(t descriptor.FieldDescriptorProto_Type) *descriptor.FieldDescriptorProto_Type {
+	return &t
+}
+
+func labelPtr(l descriptor.FieldDescriptorProto_Label) *descriptor.FieldDescriptorProto_Label {
+	return &l
+}
+
+func TestSingularFieldTypeScalars(t *testing.T) {
+	cases := []struct {
+		name string
+		typ  descriptor.FieldDescriptorProto_Type
+		want string
+	}{
+		{"float", descriptor.FieldDescriptorProto_TYPE_FLOAT, "number"},
+		{"sint32", descriptor.FieldDescriptorProto_TYPE_SINT32, "number"},
+		{"bytes", descriptor.FieldDescriptorProto_TYPE_BYTES, "Uint8Array"},
+		{"int64", descriptor.FieldDescriptorProto_TYPE_INT64, "string | number"},
+		{"uint64", descriptor.FieldDescriptorProto_TYPE_UINT64, "string | number"},
+		{"sfixed64", descriptor.FieldDescriptorProto_TYPE_SFIXED64, "string | number"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := &descriptor.FieldDescriptorProto{Type: fieldTypePtr(c.typ)}
+			if got := singularFieldType(f, nil); got != c.want {
+				t.Errorf("singularFieldType(%s) = %q, want %q", c.name, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSingularFieldTypeEnumStripsPackagePrefix(t *testing.T) {
+	fp := &descriptor.FileDescriptorProto{Package: strPtr("pkg")}
+	f := &descriptor.FieldDescriptorProto{
+		Type:     fieldTypePtr(descriptor.FieldDescriptorProto_TYPE_ENUM),
+		TypeName: strPtr(".pkg.Status"),
+	}
+
+	if got, want := singularFieldType(f, fp), "Status"; got != want {
+		t.Errorf("singularFieldType(enum) = %q, want %q", got, want)
+	}
+}
+
+func TestFieldTypeWrapsUnionJSONTypeInParensWhenRepeated(t *testing.T) {
+	f := &fieldValues{JSONType: "string | number", IsRepeated: true}
+	if got, want := fieldType(f), "(string | number)[]"; got != want {
+		t.Errorf("fieldType() = %q, want %q", got, want)
+	}
+}
+
+func TestSingularFieldJSONTypeBytesAndInt64EncodeAsString(t *testing.T) {
+	cases := []struct {
+		name string
+		typ  descriptor.FieldDescriptorProto_Type
+	}{
+		{"bytes", descriptor.FieldDescriptorProto_TYPE_BYTES},
+		{"int64", descriptor.FieldDescriptorProto_TYPE_INT64},
+		{"uint64", descriptor.FieldDescriptorProto_TYPE_UINT64},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := &descriptor.FieldDescriptorProto{Type: fieldTypePtr(c.typ)}
+			if got, want := singularFieldJSONType(f, nil), "string"; got != want {
+				t.Errorf("singularFieldJSONType(%s) = %q, want %q", c.name, got, want)
+			}
+		})
+	}
+}
+
+func TestSingularFieldJSONTypeWrapperUnwrapsToScalarJSONType(t *testing.T) {
+	f := &descriptor.FieldDescriptorProto{
+		Type:     fieldTypePtr(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+		TypeName: strPtr(".google.protobuf.Int64Value"),
+	}
+
+	if got, want := singularFieldType(f, nil), "string | number | null"; got != want {
+		t.Errorf("singularFieldType(Int64Value) = %q, want %q", got, want)
+	}
+	if got, want := singularFieldJSONType(f, nil), "string | null"; got != want {
+		t.Errorf("singularFieldJSONType(Int64Value) = %q, want %q", got, want)
+	}
+}
+
+func newMapEntryMessage() (*descriptor.DescriptorProto, *descriptor.FieldDescriptorProto) {
+	entry := &descriptor.DescriptorProto{
+		Name:    strPtr("TagsEntry"),
+		Options: &descriptor.MessageOptions{MapEntry: boolPtr(true)},
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: strPtr("key"), Number: int32Ptr(1), Type: fieldTypePtr(descriptor.FieldDescriptorProto_TYPE_STRING)},
+			{Name: strPtr("value"), Number: int32Ptr(2), Type: fieldTypePtr(descriptor.FieldDescriptorProto_TYPE_STRING)},
+		},
+	}
+
+	field := &descriptor.FieldDescriptorProto{
+		Name:     strPtr("tags"),
+		Number:   int32Ptr(1),
+		Type:     fieldTypePtr(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+		TypeName: strPtr(".pkg.Thing.TagsEntry"),
+		Label:    labelPtr(descriptor.FieldDescriptorProto_LABEL_REPEATED),
+	}
+
+	message := &descriptor.DescriptorProto{
+		Name:       strPtr("Thing"),
+		NestedType: []*descriptor.DescriptorProto{entry},
+		Field:      []*descriptor.FieldDescriptorProto{field},
+	}
+
+	return message, field
+}
+
+func TestMapEntryType(t *testing.T) {
+	message, field := newMapEntryMessage()
+
+	entry, ok := mapEntryType(message, field)
+	if !ok {
+		t.Fatal("mapEntryType() = false, want true")
+	}
+	if got, want := entry.GetName(), "TagsEntry"; got != want {
+		t.Errorf("mapEntryType() returned %q, want %q", got, want)
+	}
+}
+
+func TestMapEntryTypeIgnoresRegularNestedMessage(t *testing.T) {
+	message := &descriptor.DescriptorProto{
+		Name:       strPtr("Thing"),
+		NestedType: []*descriptor.DescriptorProto{{Name: strPtr("Inner")}},
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:     strPtr("inner"),
+				Number:   int32Ptr(1),
+				Type:     fieldTypePtr(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: strPtr(".pkg.Thing.Inner"),
+			},
+		},
+	}
+
+	if _, ok := mapEntryType(message, message.GetField()[0]); ok {
+		t.Error("mapEntryType() = true for a regular nested message, want false")
+	}
+}
+
+func TestBuildMessageMapFieldDoesNotEmitEntryAsNestedType(t *testing.T) {
+	file := &descriptor.FileDescriptorProto{Name: strPtr("thing.proto"), Package: strPtr("pkg")}
+	message, _ := newMapEntryMessage()
+
+	resolver := dependencyResolver{}
+	pfile := &protoFile{Imports: map[string]*importValues{}}
+
+	v := buildMessage(file, message, &resolver, pfile, nil, commentMap{}, nil, "")
+
+	if len(v.NestedTypes) != 0 {
+		t.Errorf("len(v.NestedTypes) = %d, want 0 (map entry should not be emitted as a nested type)", len(v.NestedTypes))
+	}
+	if len(v.Fields) != 1 {
+		t.Fatalf("len(v.Fields) = %d, want 1", len(v.Fields))
+	}
+	if got, want := v.Fields[0].Type, "{ [key: string]: string }"; got != want {
+		t.Errorf("v.Fields[0].Type = %q, want %q", got, want)
+	}
+}
+
+func TestTwirpRoutePath(t *testing.T) {
+	cases := []struct {
+		name    string
+		pkg     string
+		service string
+		method  string
+		want    string
+	}{
+		{"with package", "pkg", "Foo", "Bar", "/twirp/pkg.Foo/Bar"},
+		{"without package", "", "Foo", "Bar", "/twirp/Foo/Bar"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := twirpRoutePath(c.pkg, c.service, c.method); got != c.want {
+				t.Errorf("twirpRoutePath(%q, %q, %q) = %q, want %q", c.pkg, c.service, c.method, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRenderServiceServerEmitsDefinitionAndServerFactory(t *testing.T) {
+	v := &serviceValues{
+		Name: "Foo",
+		Methods: []*serviceMethodValues{
+			{Name: "Bar", Path: "/twirp/pkg.Foo/Bar"},
+		},
+	}
+
+	got := renderServiceServer(v)
+
+	for _, want := range []string{
+		`export const FooDefinition: TwirpServiceDefinition = {`,
+		`{ name: "bar", path: "/twirp/pkg.Foo/Bar" }`,
+		`export function createFooServer(impl: TwirpServiceImpl)`,
+		`return createTwirpHandler(FooDefinition, impl);`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("renderServiceServer() = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestBuildMessageMapFieldWithCrossFileMessageValueRegistersImport(t *testing.T) {
+	file := &descriptor.FileDescriptorProto{Name: strPtr("thing.proto"), Package: strPtr("pkg")}
+	otherFile := &descriptor.FileDescriptorProto{Name: strPtr("other.proto"), Package: strPtr("pkg")}
+
+	entry := &descriptor.DescriptorProto{
+		Name:    strPtr("WidgetsEntry"),
+		Options: &descriptor.MessageOptions{MapEntry: boolPtr(true)},
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: strPtr("key"), Number: int32Ptr(1), Type: fieldTypePtr(descriptor.FieldDescriptorProto_TYPE_STRING)},
+			{
+				Name:     strPtr("value"),
+				Number:   int32Ptr(2),
+				Type:     fieldTypePtr(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: strPtr(".pkg.Widget"),
+			},
+		},
+	}
+
+	field := &descriptor.FieldDescriptorProto{
+		Name:     strPtr("widgets"),
+		Number:   int32Ptr(1),
+		Type:     fieldTypePtr(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+		TypeName: strPtr(".pkg.Thing.WidgetsEntry"),
+		Label:    labelPtr(descriptor.FieldDescriptorProto_LABEL_REPEATED),
+	}
+
+	message := &descriptor.DescriptorProto{
+		Name:       strPtr("Thing"),
+		NestedType: []*descriptor.DescriptorProto{entry},
+		Field:      []*descriptor.FieldDescriptorProto{field},
+	}
+
+	resolver := dependencyResolver{}
+	resolver.Set(otherFile, "Widget")
+	pfile := &protoFile{Imports: map[string]*importValues{}}
+
+	buildMessage(file, message, &resolver, pfile, defaultParams(), commentMap{}, nil, "")
+
+	imp, ok := pfile.Imports[otherFile.GetName()]
+	if !ok {
+		t.Fatalf("pfile.Imports[%q] missing, want an import registered for the map value's message type", otherFile.GetName())
+	}
+	if got, want := imp.Name, "other"; got != want {
+		t.Errorf("imp.Name = %q, want %q", got, want)
+	}
+}
+
+func TestBuildMessageNestedEnumNameIsFlattenedAndRegisteredWithResolver(t *testing.T) {
+	file := &descriptor.FileDescriptorProto{Name: strPtr("thing.proto"), Package: strPtr("pkg")}
+	message := &descriptor.DescriptorProto{
+		Name: strPtr("Outer"),
+		NestedType: []*descriptor.DescriptorProto{
+			{
+				Name: strPtr("Middle"),
+				EnumType: []*descriptor.EnumDescriptorProto{
+					{Name: strPtr("Status")},
+				},
+			},
+		},
+	}
+
+	resolver := dependencyResolver{}
+	pfile := &protoFile{Imports: map[string]*importValues{}}
+
+	v := buildMessage(file, message, &resolver, pfile, nil, commentMap{}, nil, "")
+
+	if len(v.NestedTypes) != 1 {
+		t.Fatalf("len(v.NestedTypes) = %d, want 1", len(v.NestedTypes))
+	}
+
+	middle := v.NestedTypes[0]
+	if len(middle.NestedEnums) != 1 {
+		t.Fatalf("len(middle.NestedEnums) = %d, want 1", len(middle.NestedEnums))
+	}
+
+	if got, want := middle.NestedEnums[0].Name, "Outer_Middle_Status"; got != want {
+		t.Errorf("nested enum Name = %q, want %q", got, want)
+	}
+
+	if _, err := resolver.Resolve(".pkg.Outer.Middle.Status"); err != nil {
+		t.Errorf("resolver.Resolve(%q) failed, want the nested enum's dotted qualified name to be registered: %v", ".pkg.Outer.Middle.Status", err)
+	}
+}
+
+func TestSingularFieldTypeNestedEnumMatchesFlattenedName(t *testing.T) {
+	fp := &descriptor.FileDescriptorProto{Package: strPtr("pkg")}
+	f := &descriptor.FieldDescriptorProto{
+		Type:     fieldTypePtr(descriptor.FieldDescriptorProto_TYPE_ENUM),
+		TypeName: strPtr(".pkg.Outer.Middle.Status"),
+	}
+
+	if got, want := singularFieldType(f, fp), "Outer_Middle_Status"; got != want {
+		t.Errorf("singularFieldType(nested enum) = %q, want %q", got, want)
+	}
+}
+
+func TestBuildMessageGroupsRealOneofsButExcludesProto3Optional(t *testing.T) {
+	file := &descriptor.FileDescriptorProto{Name: strPtr("thing.proto"), Package: strPtr("pkg")}
+	message := &descriptor.DescriptorProto{
+		Name: strPtr("Thing"),
+		OneofDecl: []*descriptor.OneofDescriptorProto{
+			{Name: strPtr("value")},
+			{Name: strPtr("_nickname")}, // synthetic proto3 optional oneof
+		},
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:       strPtr("text"),
+				Number:     int32Ptr(1),
+				Type:       fieldTypePtr(descriptor.FieldDescriptorProto_TYPE_STRING),
+				OneofIndex: int32Ptr(0),
+			},
+			{
+				Name:       strPtr("number"),
+				Number:     int32Ptr(2),
+				Type:       fieldTypePtr(descriptor.FieldDescriptorProto_TYPE_INT32),
+				OneofIndex: int32Ptr(0),
+			},
+			{
+				Name:           strPtr("nickname"),
+				Number:         int32Ptr(3),
+				Type:           fieldTypePtr(descriptor.FieldDescriptorProto_TYPE_STRING),
+				OneofIndex:     int32Ptr(1),
+				Proto3Optional: boolPtr(true),
+			},
+		},
+	}
+
+	resolver := dependencyResolver{}
+	pfile := &protoFile{Imports: map[string]*importValues{}}
+
+	v := buildMessage(file, message, &resolver, pfile, nil, commentMap{}, nil, "")
+
+	if len(v.Fields) != 3 {
+		t.Fatalf("len(v.Fields) = %d, want 3 (every field is still rendered individually)", len(v.Fields))
+	}
+
+	if len(v.Oneofs) != 1 {
+		t.Fatalf("len(v.Oneofs) = %d, want 1 (the synthetic proto3-optional oneof must be excluded)", len(v.Oneofs))
+	}
+	if got, want := v.Oneofs[0].Name, "value"; got != want {
+		t.Errorf("v.Oneofs[0].Name = %q, want %q", got, want)
+	}
+	if len(v.Oneofs[0].Fields) != 2 {
+		t.Errorf("len(v.Oneofs[0].Fields) = %d, want 2", len(v.Oneofs[0].Fields))
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Supported values for the import_style parameter.
+const (
+	ImportStyleES6       = "es6"
+	ImportStyleCommonJS  = "commonjs"
+	ImportStyleNamespace = "namespace"
+)
+
+// Supported values for the runtime parameter.
+const (
+	RuntimeFetch   = "fetch"
+	RuntimeAxios   = "axios"
+	RuntimeGRPCWeb = "grpc-web"
+)
+
+// Supported values for the paths parameter.
+const (
+	PathsSourceRelative = "source_relative"
+	PathsImport         = "import"
+)
+
+// params holds the generator options passed via the protoc
+// `--twirp_ts_out=PARAMS:OUTDIR` flag, parsed from
+// CodeGeneratorRequest.Parameter.
+type params struct {
+	ImportStyle  string
+	Runtime      string
+	TSNoCheck    bool
+	EmitDefaults bool
+	Paths        string
+
+	// Server enables emitting Twirp server-side route handlers alongside
+	// the client interfaces.
+	Server bool
+}
+
+// defaultParams returns the params used when no parameter string is given.
+func defaultParams() *params {
+	return &params{
+		ImportStyle: ImportStyleES6,
+		Runtime:     RuntimeFetch,
+		Paths:       PathsImport,
+	}
+}
+
+// parseParameter parses the comma-separated `key=value` parameter string
+// protoc passes through on CodeGeneratorRequest.Parameter, the same
+// convention used by protoc-gen-go.
+func parseParameter(parameter string) (*params, error) {
+	p := defaultParams()
+
+	if parameter == "" {
+		return p, nil
+	}
+
+	for _, param := range strings.Split(parameter, ",") {
+		if param == "" {
+			continue
+		}
+
+		key := param
+		value := ""
+		if i := strings.Index(param, "="); i >= 0 {
+			key, value = param[:i], param[i+1:]
+		}
+
+		switch key {
+		case "import_style":
+			switch value {
+			case ImportStyleES6, ImportStyleCommonJS, ImportStyleNamespace:
+				p.ImportStyle = value
+			default:
+				return nil, fmt.Errorf("twirp_ts: unknown import_style %q", value)
+			}
+		case "runtime":
+			switch value {
+			case RuntimeFetch, RuntimeAxios, RuntimeGRPCWeb:
+				p.Runtime = value
+			default:
+				return nil, fmt.Errorf("twirp_ts: unknown runtime %q", value)
+			}
+		case "paths":
+			switch value {
+			case PathsSourceRelative, PathsImport:
+				p.Paths = value
+			default:
+				return nil, fmt.Errorf("twirp_ts: unknown paths %q", value)
+			}
+		case "ts_nocheck":
+			p.TSNoCheck = value == "true"
+		case "emit_defaults":
+			p.EmitDefaults = value == "true"
+		case "server":
+			p.Server = value == "true"
+		default:
+			return nil, fmt.Errorf("twirp_ts: unknown parameter %q", key)
+		}
+	}
+
+	return p, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestParseParameterServer(t *testing.T) {
+	p, err := parseParameter("server=true")
+	if err != nil {
+		t.Fatalf("parseParameter() error = %v", err)
+	}
+	if !p.Server {
+		t.Error("p.Server = false, want true")
+	}
+}
+
+func TestParseParameterServerDefaultsFalse(t *testing.T) {
+	p, err := parseParameter("")
+	if err != nil {
+		t.Fatalf("parseParameter() error = %v", err)
+	}
+	if p.Server {
+		t.Error("p.Server = true, want false (default)")
+	}
+}
+
+func TestParseParameterServerCombinesWithOtherParams(t *testing.T) {
+	p, err := parseParameter("import_style=commonjs,server=true")
+	if err != nil {
+		t.Fatalf("parseParameter() error = %v", err)
+	}
+	if got, want := p.ImportStyle, ImportStyleCommonJS; got != want {
+		t.Errorf("p.ImportStyle = %q, want %q", got, want)
+	}
+	if !p.Server {
+		t.Error("p.Server = false, want true")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+// twirpServerFileName is the name of the generated file carrying the
+// framework-agnostic server runtime, written alongside the per-service
+// generated files when the server=true plugin parameter is set.
+var twirpServerFileName = "twirp_server.ts"
+
+// twirpServerSource is the static TypeScript runtime used by generated
+// server route handlers. It implements the Twirp HTTP spec: dispatching
+// on the request Content-Type, decoding the request body into the
+// method's input JSON interface, invoking the user-provided service
+// implementation, and encoding the response (or a Twirp error envelope
+// on failure). It has no dependency on express or node:http beyond the
+// minimal request/response shape every Node framework exposes.
+//
+// application/protobuf is only served for routes that supply a
+// ProtobufCodec; a route without one answers protobuf requests with a
+// Twirp "unimplemented" error instead of mishandling the wire format.
+var twirpServerSource = `
+export interface TwirpServerRequest {
+  method: string;
+  headers: { [key: string]: string | string[] | undefined };
+  body: any;
+}
+
+export interface TwirpServerResponse {
+  statusCode: number;
+  setHeader(name: string, value: string): void;
+  end(chunk: string | Uint8Array): void;
+}
+
+export class TwirpError extends Error {
+  constructor(
+    public code: string,
+    msg: string,
+    public meta: { [key: string]: string } = {}
+  ) {
+    super(msg);
+  }
+}
+
+const errorCodeToStatus: { [code: string]: number } = {
+  canceled: 408,
+  unknown: 500,
+  invalid_argument: 400,
+  malformed: 400,
+  deadline_exceeded: 408,
+  not_found: 404,
+  bad_route: 404,
+  already_exists: 409,
+  permission_denied: 403,
+  unauthenticated: 401,
+  resource_exhausted: 403,
+  failed_precondition: 412,
+  aborted: 409,
+  out_of_range: 400,
+  unimplemented: 501,
+  internal: 500,
+  unavailable: 503,
+  dataloss: 500,
+};
+
+function writeError(res: TwirpServerResponse, err: TwirpError): void {
+  const status = errorCodeToStatus[err.code] || 500;
+  res.statusCode = status;
+  res.setHeader("Content-Type", "application/json");
+  res.end(JSON.stringify({ code: err.code, msg: err.message, meta: err.meta }));
+}
+
+// ProtobufCodec serializes and deserializes a route's input/output
+// messages to and from the protobuf binary wire format. Generated
+// per-message code (e.g. a future toBinary()/fromBinary() pair on each
+// message interface) is expected to supply this; createTwirpHandler has
+// no binary protobuf support of its own.
+export interface ProtobufCodec {
+  decode: (body: Uint8Array) => any;
+  encode: (message: any) => Uint8Array;
+}
+
+// TwirpServiceMethod describes one RPC method's route, generated per
+// service alongside its TwirpServiceDefinition (see e.g. FooDefinition in
+// the .ts file generated for the service's .proto file).
+export interface TwirpServiceMethod {
+  name: string;
+  path: string;
+  // When omitted, the route only serves application/json requests; an
+  // application/protobuf request is rejected with a Twirp "unimplemented"
+  // error rather than being silently mishandled.
+  protobuf?: ProtobufCodec;
+}
+
+// TwirpServiceDefinition is the generated description of a service's
+// routes, passed to createTwirpHandler alongside a matching service
+// implementation.
+export interface TwirpServiceDefinition {
+  name: string;
+  methods: TwirpServiceMethod[];
+}
+
+// TwirpServiceImpl is satisfied by any object exposing one async method
+// per TwirpServiceMethod.name, e.g. the service interface generated
+// alongside a TwirpServiceDefinition.
+export type TwirpServiceImpl = {
+  [method: string]: (input: any, headers: TwirpServerRequest["headers"]) => Promise<any>;
+};
+
+interface twirpRoute {
+  path: string;
+  handle: (input: any, headers: TwirpServerRequest["headers"]) => Promise<any>;
+  protobuf?: ProtobufCodec;
+}
+
+// createTwirpHandler builds a (req, res) handler that wires each method in
+// service to the matching method on impl and dispatches by exact path
+// match, matching the "POST /twirp/<pkg>.<Service>/<Method>" convention.
+// It is agnostic to the HTTP framework in use: the req/res shapes above
+// are satisfied by express, node:http, and most other Node frameworks
+// without adapting.
+export function createTwirpHandler(service: TwirpServiceDefinition, impl: TwirpServiceImpl) {
+  const routes: twirpRoute[] = service.methods.map((method) => ({
+    path: method.path,
+    protobuf: method.protobuf,
+    handle: (input: any, headers: TwirpServerRequest["headers"]) => impl[method.name](input, headers),
+  }));
+  const routesByPath = new Map(routes.map((route) => [route.path, route]));
+
+  return async (req: TwirpServerRequest, res: TwirpServerResponse): Promise<void> => {
+    const route = routesByPath.get(req.method === "POST" ? urlPath(req) : "");
+    if (!route) {
+      writeError(res, new TwirpError("bad_route", "no route for path"));
+      return;
+    }
+
+    const contentType = String(req.headers["content-type"] || "");
+    const isJSON = contentType.indexOf("application/json") !== -1;
+    const isProtobuf = contentType.indexOf("application/protobuf") !== -1;
+
+    if (!isJSON && !isProtobuf) {
+      writeError(res, new TwirpError("malformed", "unexpected Content-Type: " + contentType));
+      return;
+    }
+
+    if (isProtobuf && !route.protobuf) {
+      writeError(res, new TwirpError("unimplemented", "this route does not support Content-Type: application/protobuf"));
+      return;
+    }
+
+    try {
+      const input = isJSON ? req.body : route.protobuf!.decode(req.body);
+      const output = await route.handle(input, req.headers);
+
+      res.statusCode = 200;
+      res.setHeader("Content-Type", isProtobuf ? "application/protobuf" : "application/json");
+      res.end(isProtobuf ? route.protobuf!.encode(output) : JSON.stringify(output));
+    } catch (err) {
+      if (err instanceof TwirpError) {
+        writeError(res, err);
+      } else {
+        writeError(res, new TwirpError("internal", err instanceof Error ? err.message : String(err)));
+      }
+    }
+  };
+}
+
+function urlPath(req: TwirpServerRequest): string {
+  return (req as any).url || (req as any).path || "";
+}
+`
@@ -0,0 +1,59 @@
+package main
+
+// wktType describes how a google.protobuf well-known type is represented
+// in the generated TypeScript: its plain interface type, its JSON (jsonpb)
+// interface type, and whether it's one of the nullable wrapper types.
+type wktType struct {
+	TSType   string
+	JSONType string
+	Nullable bool
+}
+
+// wellKnownTypes centralizes the google.protobuf well-known type mapping so
+// singularFieldType (plain interfaces) and the JSON interface generation
+// agree on the runtime representation of each type.
+var wellKnownTypes = map[string]wktType{
+	".google.protobuf.Timestamp": {TSType: "Date", JSONType: "string"},
+	".google.protobuf.Duration":  {TSType: "string", JSONType: "string"},
+	".google.protobuf.Empty":     {TSType: "{}", JSONType: "{}"},
+	".google.protobuf.Struct":    {TSType: "object", JSONType: "object"},
+	".google.protobuf.Value":     {TSType: "any", JSONType: "any"},
+	".google.protobuf.ListValue": {TSType: "any[]", JSONType: "any[]"},
+	".google.protobuf.NullValue": {TSType: "null", JSONType: "null"},
+	".google.protobuf.FieldMask": {TSType: "string", JSONType: "string"},
+	".google.protobuf.Any":       {TSType: "{ typeUrl: string; value: string }", JSONType: "{ typeUrl: string; value: string }"},
+
+	// Wrapper types unwrap to their scalar equivalent, nullable because
+	// jsonpb represents an absent wrapper as null rather than omitting
+	// the field.
+	".google.protobuf.StringValue": {TSType: "string", JSONType: "string", Nullable: true},
+	".google.protobuf.BoolValue":   {TSType: "boolean", JSONType: "boolean", Nullable: true},
+	".google.protobuf.Int32Value":  {TSType: "number", JSONType: "number", Nullable: true},
+	".google.protobuf.UInt32Value": {TSType: "number", JSONType: "number", Nullable: true},
+	".google.protobuf.FloatValue":  {TSType: "number", JSONType: "number", Nullable: true},
+	".google.protobuf.DoubleValue": {TSType: "number", JSONType: "number", Nullable: true},
+	".google.protobuf.Int64Value":  {TSType: "string | number", JSONType: "string", Nullable: true},
+	".google.protobuf.UInt64Value": {TSType: "string | number", JSONType: "string", Nullable: true},
+	".google.protobuf.BytesValue":  {TSType: "Uint8Array", JSONType: "string", Nullable: true},
+}
+
+// wellKnownType looks up the TS/JSON mapping for a fully-qualified
+// google.protobuf well-known type name, e.g. ".google.protobuf.Duration".
+func wellKnownType(typeName string) (wktType, bool) {
+	w, ok := wellKnownTypes[typeName]
+	return w, ok
+}
+
+func (w wktType) tsType() string {
+	if w.Nullable {
+		return w.TSType + " | null"
+	}
+	return w.TSType
+}
+
+func (w wktType) jsonType() string {
+	if w.Nullable {
+		return w.JSONType + " | null"
+	}
+	return w.JSONType
+}